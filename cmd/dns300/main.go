@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"dns300/internal/config"
 	"dns300/internal/device"
+	"dns300/internal/metrics"
 	"dns300/internal/server"
 	"dns300/internal/upstream"
 	"flag"
@@ -11,6 +13,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -38,8 +41,16 @@ func main() {
 
 	// Initialize Components
 	devManager := device.NewManager(cfg)
-	client := upstream.NewClient()
-	srv := server.NewServer(cfg, devManager, client)
+	m := metrics.New()
+	client := upstream.NewClient(cfg.Bootstrap, m)
+	srv := server.NewServer(cfg, devManager, client, m)
+
+	if cfg.MetricsAddr != "" {
+		if err := m.Start(cfg.MetricsAddr); err != nil {
+			log.Fatalf("Failed to start metrics server: %v", err)
+		}
+		log.Printf("Serving metrics on %s", cfg.MetricsAddr)
+	}
 
 	// Start Servers
 	addr := fmt.Sprintf(":%d", *port)
@@ -67,4 +78,16 @@ func main() {
 	<-sig
 
 	log.Println("Shutting down...")
+
+	if cfg.MetricsAddr != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := m.Stop(ctx); err != nil {
+			log.Printf("Failed to stop metrics server: %v", err)
+		}
+		cancel()
+	}
+
+	if err := srv.Close(); err != nil {
+		log.Printf("Failed to close server: %v", err)
+	}
 }
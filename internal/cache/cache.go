@@ -0,0 +1,216 @@
+// Package cache implements an in-memory LRU cache of DNS responses, with
+// negative caching and stale-while-revalidate support.
+package cache
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Key identifies a cached response. DNSSECOk and CheckingDisabled are part
+// of the key because they change what a resolver may legitimately return
+// for the same qname/qtype/qclass. Upstreams is part of the key because
+// device and domain-based routing rules can send the same qname to
+// different upstreams depending on the client; without it, a split-horizon
+// name resolved for one routing target would be served from the cache to a
+// client that should route elsewhere.
+type Key struct {
+	Name             string
+	Qtype            uint16
+	Qclass           uint16
+	DNSSECOk         bool
+	CheckingDisabled bool
+	Upstreams        string
+}
+
+// KeyForRequest derives a Cache key from an inbound query. upstreams is the
+// resolved set of upstreams the query would be forwarded to, after device
+// and routing-rule selection, so entries are scoped to the routing decision
+// and not shared across clients routed differently.
+func KeyForRequest(req *dns.Msg, upstreams []string) Key {
+	q := req.Question[0]
+
+	var dnssecOk bool
+	if opt := req.IsEdns0(); opt != nil {
+		dnssecOk = opt.Do()
+	}
+
+	return Key{
+		Name:             strings.ToLower(q.Name),
+		Qtype:            q.Qtype,
+		Qclass:           q.Qclass,
+		DNSSECOk:         dnssecOk,
+		CheckingDisabled: req.CheckingDisabled,
+		Upstreams:        strings.Join(upstreams, ","),
+	}
+}
+
+type entry struct {
+	key       Key
+	msg       *dns.Msg
+	cachedAt  time.Time
+	expiresAt time.Time
+	staleAt   time.Time // expiresAt + serveStale window; equal to expiresAt if stale serving is disabled
+}
+
+// Cache is an LRU response cache with negative caching and optional
+// stale-while-revalidate support.
+type Cache struct {
+	maxEntries  int
+	negativeTTL time.Duration
+	serveStale  time.Duration
+
+	mu    sync.Mutex
+	ll    *list.List // front = most recently used
+	items map[Key]*list.Element
+}
+
+// New builds a Cache holding at most maxEntries responses. negativeTTL is
+// used for NXDOMAIN/NODATA responses that carry no SOA MINIMUM. serveStale,
+// if positive, lets expired entries keep answering for that long while a
+// refresh is triggered in the background.
+func New(maxEntries int, negativeTTL, serveStale time.Duration) *Cache {
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = 60 * time.Second
+	}
+	return &Cache{
+		maxEntries:  maxEntries,
+		negativeTTL: negativeTTL,
+		serveStale:  serveStale,
+		ll:          list.New(),
+		items:       make(map[Key]*list.Element),
+	}
+}
+
+// Get returns a cached response for key with its TTLs decremented by the
+// time spent in the cache. stale reports whether the entry is past its
+// normal expiry but still within the serve-stale window.
+func (c *Cache) Get(key Key) (msg *dns.Msg, stale bool, ok bool) {
+	c.mu.Lock()
+	e, el, found := c.lookup(key)
+	c.mu.Unlock()
+	if !found {
+		return nil, false, false
+	}
+
+	now := time.Now()
+	resp := e.msg.Copy()
+	decrementTTL(resp, now.Sub(e.cachedAt))
+
+	c.mu.Lock()
+	c.ll.MoveToFront(el)
+	c.mu.Unlock()
+
+	return resp, now.After(e.expiresAt), true
+}
+
+func (c *Cache) lookup(key Key) (*entry, *list.Element, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, nil, false
+	}
+	e := el.Value.(*entry)
+
+	if time.Now().After(e.staleAt) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, nil, false
+	}
+	return e, el, true
+}
+
+// Set stores resp under key, computing its expiry from the minimum RR TTL
+// in the response, or the negative TTL for NXDOMAIN/NODATA responses.
+// Truncated responses and SERVFAILs are never cached.
+func (c *Cache) Set(key Key, resp *dns.Msg) {
+	if resp.Truncated || resp.Rcode == dns.RcodeServerFailure {
+		return
+	}
+
+	ttl, has := minTTL(resp)
+	switch {
+	case has && ttl <= 0:
+		// The origin answered with RRs but an explicit TTL of 0, meaning
+		// "don't cache this" — honor that instead of treating it as "no TTL
+		// found" and caching it under the negative TTL.
+		return
+	case !has:
+		ttl = c.negativeTTL
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	staleAt := expiresAt
+	if c.serveStale > 0 {
+		staleAt = expiresAt.Add(c.serveStale)
+	}
+	e := &entry{key: key, msg: resp.Copy(), cachedAt: now, expiresAt: expiresAt, staleAt: staleAt}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	el := c.ll.PushFront(e)
+	c.items[key] = el
+
+	if c.ll.Len() > c.maxEntries {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.items, oldest.Value.(*entry).key)
+		}
+	}
+}
+
+// minTTL returns the minimum RR TTL across the answer section and whether
+// one was found, falling back to the SOA MINIMUM from the authority section
+// for a negative response. has is false only when neither is present, in
+// which case the caller falls back to the configured negative TTL.
+func minTTL(resp *dns.Msg) (ttl time.Duration, has bool) {
+	var min uint32
+	for _, rr := range resp.Answer {
+		if !has || rr.Header().Ttl < min {
+			min = rr.Header().Ttl
+			has = true
+		}
+	}
+	if has {
+		return time.Duration(min) * time.Second, true
+	}
+
+	for _, rr := range resp.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return time.Duration(soa.Minttl) * time.Second, true
+		}
+	}
+	return 0, false
+}
+
+func decrementTTL(msg *dns.Msg, elapsed time.Duration) {
+	secs := uint32(elapsed / time.Second)
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			hdr := rr.Header()
+			if hdr.Rrtype == dns.TypeOPT {
+				continue
+			}
+			if hdr.Ttl > secs {
+				hdr.Ttl -= secs
+			} else {
+				hdr.Ttl = 0
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func answerMsg(qname string, ttl uint32) *dns.Msg {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(qname), dns.TypeA)
+	msg.Answer = []dns.RR{
+		&dns.A{
+			Hdr: dns.RR_Header{Name: dns.Fqdn(qname), Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+		},
+	}
+	return msg
+}
+
+func TestSetDoesNotCacheZeroTTLAnswer(t *testing.T) {
+	c := New(10, time.Minute, 0)
+	key := Key{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.Set(key, answerMsg("example.com.", 0))
+
+	if _, _, ok := c.Get(key); ok {
+		t.Fatal("expected a TTL-0 answer not to be cached")
+	}
+}
+
+func TestSetCachesPositiveTTLAnswer(t *testing.T) {
+	c := New(10, time.Minute, 0)
+	key := Key{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	c.Set(key, answerMsg("example.com.", 300))
+
+	if _, _, ok := c.Get(key); !ok {
+		t.Fatal("expected a positive-TTL answer to be cached")
+	}
+}
+
+func TestSetUsesNegativeTTLWhenNoneFound(t *testing.T) {
+	c := New(10, 45*time.Second, 0)
+	key := Key{Name: "nx.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn("nx.example.com."), dns.TypeA)
+	msg.Rcode = dns.RcodeNameError
+	c.Set(key, msg)
+
+	_, _, ok := c.Get(key)
+	if !ok {
+		t.Fatal("expected a response with no TTL to be cached under the negative TTL")
+	}
+}
+
+func TestKeyForRequestScopesByUpstreams(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn("split-horizon.example."), dns.TypeA)
+
+	internal := KeyForRequest(req, []string{"10.0.0.1:53"})
+	external := KeyForRequest(req, []string{"1.1.1.1:53"})
+
+	if internal == external {
+		t.Fatal("expected requests routed to different upstreams to get different cache keys")
+	}
+}
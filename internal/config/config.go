@@ -3,20 +3,102 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Duration wraps time.Duration so it can be parsed from YAML strings like
+// "30s" instead of raw nanosecond integers.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
 type Config struct {
 	Upstreams []string `yaml:"upstreams"`
 	Devices   []Device `yaml:"devices"`
+	// Bootstrap servers resolve hostname-based DoH/DoT upstreams. Required
+	// when such an upstream is configured, since the OS resolver on a
+	// router running dns300 is dns300 itself.
+	Bootstrap []string `yaml:"bootstrap"`
+	// Rules route queries to different upstreams based on the queried name,
+	// independent of which device asked. The longest matching suffix wins.
+	Rules []Rule `yaml:"rules"`
+	// Cache configures the response cache. Caching is enabled whenever
+	// MaxEntries is positive.
+	Cache CacheConfig `yaml:"cache"`
+	// Blocklists are the sources compiled into the blocklist filter.
+	// Filtering is enabled whenever at least one source or user rule is set.
+	Blocklists []BlocklistSource `yaml:"blocklists"`
+	// UserRules is a local allow/block list (AdBlock syntax) applied after
+	// the remote blocklists, so it replaces a remote entry for the same
+	// domain. The most specific matching entry still decides: a user rule for
+	// example.com does not override a remote entry for ads.example.com.
+	UserRules []string `yaml:"user_rules"`
+	// BlockingMode controls how a blocked query is answered: nxdomain
+	// (default), zeroip (0.0.0.0/::), or custom_ip (BlockingIP).
+	BlockingMode string `yaml:"blocking_mode"`
+	BlockingIP   string `yaml:"blocking_ip"`
+	// FilterRefresh is how often URL blocklist sources are re-fetched.
+	FilterRefresh Duration `yaml:"filter_refresh"`
+	// MetricsAddr, if set, serves Prometheus metrics at /metrics, e.g. ":9153".
+	MetricsAddr string `yaml:"metrics_addr"`
+	// QueryLog configures the structured query log.
+	QueryLog QueryLogConfig `yaml:"querylog"`
+}
+
+// QueryLogConfig configures the async structured query log.
+type QueryLogConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Path      string `yaml:"path"`
+	Format    string `yaml:"format"` // only "json" is supported
+	MaxSizeMB int    `yaml:"max_size_mb"`
+}
+
+// CacheConfig configures the response cache consulted before forwarding.
+type CacheConfig struct {
+	MaxEntries  int      `yaml:"max_entries"`
+	NegativeTTL Duration `yaml:"negative_ttl"`
+	ServeStale  Duration `yaml:"serve_stale"`
+}
+
+// BlocklistSource is a single configured blocklist: either a URL or a local
+// path, parsed according to Format ("hosts" or "adblock").
+type BlocklistSource struct {
+	URL    string `yaml:"url"`
+	Path   string `yaml:"path"`
+	Format string `yaml:"format"`
 }
 
 type Device struct {
 	Name      string   `yaml:"name"`
-	IPs       []string `yaml:"ips"`
+	IPs       []string `yaml:"ips"` // exact IPs or CIDR blocks, e.g. "192.168.10.0/24"
+	MACs      []string `yaml:"macs"`
 	Upstreams []string `yaml:"upstreams"`
 	TLSVerify *bool    `yaml:"tls-verify,omitempty"` // Use pointer to handle default true
+	// Rules override the global rules for this device's queries.
+	Rules []Rule `yaml:"rules"`
+	// Filter enables blocklist filtering for this device's queries.
+	// Use pointer to handle default true.
+	Filter *bool `yaml:"filter,omitempty"`
+}
+
+// Rule maps a domain suffix to the upstreams queries under it should use,
+// e.g. {suffix: "corp.example.", upstreams: [10.0.0.53]}.
+type Rule struct {
+	Suffix    string   `yaml:"suffix"`
+	Upstreams []string `yaml:"upstreams"`
 }
 
 // DefaultConfigTemplate is the template used when config file is missing
@@ -24,15 +106,59 @@ const DefaultConfigTemplate = `upstreams:
   - 1.0.0.1
   - 8.8.8.8
 
+#bootstrap:
+#  - 1.1.1.1:53
+#  - 8.8.8.8:53
+
+#rules:
+#  - suffix: corp.example.
+#    upstreams:
+#      - 10.0.0.53
+#  - suffix: cn.
+#    upstreams:
+#      - 223.5.5.5
+
+#cache:
+#  max_entries: 10000
+#  negative_ttl: 60s
+#  serve_stale: 30s
+
+#blocklists:
+#  - url: https://example.com/hosts.txt
+#    format: hosts
+#  - path: /etc/dns300/adblock.txt
+#    format: adblock
+#user_rules:
+#  - "||ads.example.com^"
+#  - "@@||ok.ads.example.com^"
+#blocking_mode: nxdomain
+#filter_refresh: 1h
+
+#metrics_addr: :9153
+
+#querylog:
+#  enabled: true
+#  path: /var/log/dns300/query.log
+#  format: json
+#  max_size_mb: 100
+
 #devices:
 #  - name: example
 #    ips:
 #      - 192.168.0.23
 #      - 192.168.2.11
+#      - 192.168.10.0/24
+#    macs:
+#      - aa:bb:cc:dd:ee:ff
 #    upstreams:
 #      - 1.0.0.2
 #      - https://cloudflare-dns.com/dns-query
 #    tls-verify: true
+#    rules:
+#      - suffix: corp.example.
+#        upstreams:
+#          - 10.0.0.53
+#    filter: true
 `
 
 func Load(path string) (*Config, error) {
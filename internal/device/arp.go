@@ -0,0 +1,119 @@
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// arpCache resolves a client IP to its MAC address via the kernel neighbor
+// table, caching results briefly so MAC-based device matching doesn't pay a
+// syscall/exec on every query.
+type arpCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]arpEntry
+}
+
+type arpEntry struct {
+	mac       string
+	expiresAt time.Time
+}
+
+func newARPCache(ttl time.Duration) *arpCache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &arpCache{ttl: ttl, entries: make(map[string]arpEntry)}
+}
+
+// lookup returns the MAC address associated with ip, refreshing the whole
+// neighbor table on a cache miss.
+func (c *arpCache) lookup(ip net.IP) (string, error) {
+	key := ip.String()
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expiresAt) {
+		c.mu.Unlock()
+		return e.mac, nil
+	}
+	c.mu.Unlock()
+
+	table, err := readNeighborTable()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	for neighIP, mac := range table {
+		c.entries[neighIP] = arpEntry{mac: mac, expiresAt: now.Add(c.ttl)}
+	}
+	c.mu.Unlock()
+
+	mac, ok := table[key]
+	if !ok {
+		return "", fmt.Errorf("no neighbor entry for %s", key)
+	}
+	return mac, nil
+}
+
+// readNeighborTable tries /proc/net/arp first (Linux), falling back to
+// parsing `ip neigh show` output where /proc isn't available.
+func readNeighborTable() (map[string]string, error) {
+	if table, err := readProcNetARP(); err == nil && len(table) > 0 {
+		return table, nil
+	}
+	return readIPNeigh()
+}
+
+func readProcNetARP() (map[string]string, error) {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header line: "IP address HW type Flags HW address Mask Device"
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		ip, mac := fields[0], strings.ToLower(fields[3])
+		if mac != "" && mac != "00:00:00:00:00:00" {
+			table[ip] = mac
+		}
+	}
+	return table, scanner.Err()
+}
+
+func readIPNeigh() (map[string]string, error) {
+	out, err := exec.Command("ip", "neigh", "show").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ip neigh show: %w", err)
+	}
+
+	table := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for i, f := range fields {
+			if f == "lladdr" && i+1 < len(fields) {
+				table[fields[0]] = strings.ToLower(fields[i+1])
+				break
+			}
+		}
+	}
+	return table, nil
+}
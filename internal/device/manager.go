@@ -2,22 +2,39 @@ package device
 
 import (
 	"dns300/internal/config"
+	"dns300/internal/routing"
 	"net"
+	"strings"
 )
 
 type Device struct {
-	Name      string
-	Upstreams []string
-	TLSVerify bool
+	Name          string
+	Upstreams     []string
+	TLSVerify     bool
+	Rules         *routing.Trie // per-device suffix overrides, empty if none configured
+	FilterEnabled bool
+}
+
+// cidrEntry pairs a parsed IP range with the device it belongs to.
+type cidrEntry struct {
+	ipnet *net.IPNet
+	dev   *Device
 }
 
 type Manager struct {
-	ipMap map[string]*Device
+	ipMap  map[string]*Device
+	cidrs  []cidrEntry
+	macMap map[string]*Device // keyed by lowercase MAC address
+	arp    *arpCache
+	rules  *routing.Trie // global suffix-based routing rules
 }
 
 func NewManager(cfg *config.Config) *Manager {
 	m := &Manager{
-		ipMap: make(map[string]*Device),
+		ipMap:  make(map[string]*Device),
+		macMap: make(map[string]*Device),
+		arp:    newARPCache(0),
+		rules:  routing.NewTrie(toRoutingRules(cfg.Rules)),
 	}
 
 	for _, d := range cfg.Devices {
@@ -25,26 +42,74 @@ func NewManager(cfg *config.Config) *Manager {
 		if d.TLSVerify != nil {
 			tlsVerify = *d.TLSVerify
 		}
+		filterEnabled := true
+		if d.Filter != nil {
+			filterEnabled = *d.Filter
+		}
 
 		dev := &Device{
-			Name:      d.Name,
-			Upstreams: d.Upstreams,
-			TLSVerify: tlsVerify,
+			Name:          d.Name,
+			Upstreams:     d.Upstreams,
+			TLSVerify:     tlsVerify,
+			Rules:         routing.NewTrie(toRoutingRules(d.Rules)),
+			FilterEnabled: filterEnabled,
 		}
 
 		for _, ipStr := range d.IPs {
-			ip := net.ParseIP(ipStr)
-			if ip != nil {
+			if _, ipnet, err := net.ParseCIDR(ipStr); err == nil {
+				m.cidrs = append(m.cidrs, cidrEntry{ipnet: ipnet, dev: dev})
+				continue
+			}
+			if ip := net.ParseIP(ipStr); ip != nil {
 				m.ipMap[ip.String()] = dev
 			}
 		}
+
+		for _, mac := range d.MACs {
+			m.macMap[strings.ToLower(mac)] = dev
+		}
 	}
 	return m
 }
 
+func toRoutingRules(rules []config.Rule) []routing.Rule {
+	out := make([]routing.Rule, len(rules))
+	for i, r := range rules {
+		out[i] = routing.Rule{Suffix: r.Suffix, Upstreams: r.Upstreams}
+	}
+	return out
+}
+
+// Get resolves a client IP to a configured Device, checking exact IPs,
+// then CIDR ranges, then (if any device declares MACs) the ARP/neighbor
+// table for a MAC match.
 func (m *Manager) Get(ip net.IP) *Device {
 	if ip == nil {
 		return nil
 	}
-	return m.ipMap[ip.String()]
+
+	if dev, ok := m.ipMap[ip.String()]; ok {
+		return dev
+	}
+
+	for _, c := range m.cidrs {
+		if c.ipnet.Contains(ip) {
+			return c.dev
+		}
+	}
+
+	if len(m.macMap) > 0 {
+		if mac, err := m.arp.lookup(ip); err == nil {
+			if dev, ok := m.macMap[mac]; ok {
+				return dev
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rules returns the global suffix-based routing rules.
+func (m *Manager) Rules() *routing.Trie {
+	return m.rules
 }
@@ -0,0 +1,15 @@
+// Package dnsname holds small DNS name helpers shared by the label-trie
+// matchers in routing and filter.
+package dnsname
+
+import "strings"
+
+// SplitLabels lowercases name, strips a trailing root dot, and splits it
+// into its dot-separated labels. It returns nil for the root name.
+func SplitLabels(name string) []string {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
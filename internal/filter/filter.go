@@ -0,0 +1,168 @@
+// Package filter loads domain blocklists (hosts-file and a subset of
+// AdBlock syntax) from local files or remote URLs and compiles them into a
+// queryable block/allow set, turning dns300 into a Pi-hole/AdGuard-style
+// filtering forwarder.
+package filter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source is a single configured blocklist source.
+type Source struct {
+	URL    string
+	Path   string
+	Format Format
+
+	// etag/lastMod/body track the last successful fetch so a 304 Not
+	// Modified response can still contribute its (unchanged) rules to a
+	// freshly rebuilt trie.
+	etag    string
+	lastMod string
+	body    []byte
+}
+
+// Filter holds the compiled block/allow set and the sources it was built
+// from, so Refresh can recompile it from scratch (remote fetch + user
+// rules) without losing ETag/Last-Modified caching.
+type Filter struct {
+	mu   sync.RWMutex
+	trie *ruleTrie
+
+	sources    []*Source
+	userRules  []string
+	httpClient *http.Client
+}
+
+// New builds a Filter. Call Refresh (or StartAutoRefresh) to populate it;
+// an unrefreshed Filter blocks nothing.
+func New(sources []Source, userRules []string) *Filter {
+	srcs := make([]*Source, len(sources))
+	for i := range sources {
+		s := sources[i]
+		srcs[i] = &s
+	}
+
+	return &Filter{
+		trie:       newRuleTrie(),
+		sources:    srcs,
+		userRules:  userRules,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Blocked reports whether the most specific entry matching qname is a block
+// entry. An allow entry overrides block entries for less specific domains,
+// and a user_rules entry replaces a remote entry for the same domain.
+func (f *Filter) Blocked(qname string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.trie.blocked(qname)
+}
+
+// Refresh reloads every configured source plus the local user_rules into a
+// new trie, then swaps it in atomically. User rules are applied last, so
+// they replace any remote entry for the same domain; a remote entry for a
+// more specific domain still takes precedence over a user rule.
+func (f *Filter) Refresh(ctx context.Context) error {
+	trie := newRuleTrie()
+
+	var firstErr error
+	for _, src := range f.sources {
+		if err := f.loadSource(ctx, src, trie); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, rule := range f.userRules {
+		parseAdblockLine(strings.TrimSpace(rule), trie)
+	}
+
+	f.mu.Lock()
+	f.trie = trie
+	f.mu.Unlock()
+
+	if firstErr != nil {
+		return fmt.Errorf("filter refresh: %w", firstErr)
+	}
+	return nil
+}
+
+// StartAutoRefresh periodically calls Refresh until ctx is cancelled. It is
+// a no-op if interval is non-positive.
+func (f *Filter) StartAutoRefresh(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := f.Refresh(ctx); err != nil {
+					log.Printf("blocklist refresh failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (f *Filter) loadSource(ctx context.Context, src *Source, trie *ruleTrie) error {
+	if src.Path != "" {
+		data, err := os.ReadFile(src.Path)
+		if err != nil {
+			return err
+		}
+		parseRules(bytes.NewReader(data), src.Format, trie)
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src.URL, nil)
+	if err != nil {
+		return err
+	}
+	if src.etag != "" {
+		req.Header.Set("If-None-Match", src.etag)
+	}
+	if src.lastMod != "" {
+		req.Header.Set("If-Modified-Since", src.lastMod)
+	}
+
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		src.body = body
+		src.etag = resp.Header.Get("ETag")
+		src.lastMod = resp.Header.Get("Last-Modified")
+	case http.StatusNotModified:
+		// Unchanged since the last fetch; reuse src.body below.
+	default:
+		return fmt.Errorf("blocklist source %s returned status %d", src.URL, resp.StatusCode)
+	}
+
+	parseRules(bytes.NewReader(src.body), src.Format, trie)
+	return nil
+}
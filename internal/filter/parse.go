@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Format identifies how a blocklist source's contents should be parsed.
+type Format string
+
+const (
+	FormatHosts   Format = "hosts"
+	FormatAdblock Format = "adblock"
+)
+
+// parseRules reads r in the given format and inserts the resulting
+// block/allow entries into trie.
+func parseRules(r io.Reader, format Format, trie *ruleTrie) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		switch format {
+		case FormatAdblock:
+			parseAdblockLine(line, trie)
+		default:
+			parseHostsLine(line, trie)
+		}
+	}
+}
+
+// parseHostsLine handles classic hosts-file syntax: "<ip> <domain> [alias...]".
+// Comments start with '#'.
+func parseHostsLine(line string, trie *ruleTrie) {
+	if strings.HasPrefix(line, "#") {
+		return
+	}
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return
+	}
+
+	// fields[0] is the IP; every remaining field is a blocked hostname.
+	for _, domain := range fields[1:] {
+		trie.insert(domain, false)
+	}
+}
+
+// parseAdblockLine handles the subset of AdBlock syntax needed for domain
+// blocking: "||domain^" to block, "@@||domain^" to allow, with basic "*"
+// wildcards within domain (e.g. "||ads-*.example.com^"). Comments and
+// cosmetic/other rule types are ignored.
+func parseAdblockLine(line string, trie *ruleTrie) {
+	if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+		return
+	}
+
+	allow := strings.HasPrefix(line, "@@")
+	line = strings.TrimPrefix(line, "@@")
+
+	if !strings.HasPrefix(line, "||") {
+		return
+	}
+	line = strings.TrimPrefix(line, "||")
+
+	end := strings.IndexAny(line, "^/")
+	if end >= 0 {
+		line = line[:end]
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	trie.insert(line, allow)
+}
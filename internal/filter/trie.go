@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"path"
+	"strings"
+
+	"dns300/internal/dnsname"
+)
+
+// ruleTrie is a longest-suffix matcher over domain labels, analogous to
+// routing.Trie, but storing a block/allow verdict instead of an upstream
+// list: a longer, more specific match always wins, so a user_rules
+// allowlist entry can carve out an exception under a blocked suffix.
+//
+// A label may itself be a glob pattern containing "*" (e.g. "ads-*" from an
+// AdBlock rule like "||ads-*.example.com^"); such labels are matched with
+// path.Match instead of an exact map lookup. At each level an exact label
+// is preferred over a glob label and the walk does not backtrack, so a
+// longer rule reachable only through a glob sibling is not found once an
+// exact label has matched.
+type ruleTrie struct {
+	root *ruleNode
+}
+
+type ruleNode struct {
+	children map[string]*ruleNode
+	terminal bool
+	allow    bool
+}
+
+func newRuleTrie() *ruleTrie {
+	return &ruleTrie{root: &ruleNode{children: make(map[string]*ruleNode)}}
+}
+
+func (t *ruleTrie) insert(domain string, allow bool) {
+	labels := dnsname.SplitLabels(domain)
+	if len(labels) == 0 {
+		return
+	}
+
+	n := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := labels[i]
+		child, ok := n.children[label]
+		if !ok {
+			child = &ruleNode{children: make(map[string]*ruleNode)}
+			n.children[label] = child
+		}
+		n = child
+	}
+	n.terminal = true
+	n.allow = allow
+}
+
+// blocked reports whether qname matches a blocklist entry and is not
+// overridden by a more specific allowlist entry. For two entries on the
+// same domain, the one inserted last wins.
+func (t *ruleTrie) blocked(qname string) bool {
+	labels := dnsname.SplitLabels(qname)
+	n := t.root
+	blocked := false
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := n.children[labels[i]]
+		if !ok {
+			child, ok = matchWildcardChild(n, labels[i])
+		}
+		if !ok {
+			break
+		}
+		n = child
+		if n.terminal {
+			blocked = !n.allow
+		}
+	}
+	return blocked
+}
+
+// matchWildcardChild scans n's children for a glob-pattern label (one
+// containing "*") that matches label, since those can't be found by a plain
+// map lookup.
+func matchWildcardChild(n *ruleNode, label string) (*ruleNode, bool) {
+	for pattern, child := range n.children {
+		if !strings.Contains(pattern, "*") {
+			continue
+		}
+		if ok, _ := path.Match(pattern, label); ok {
+			return child, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,37 @@
+package filter
+
+import "testing"
+
+func TestRuleTrieWildcard(t *testing.T) {
+	trie := newRuleTrie()
+	trie.insert("ads-*.example.com", false)
+
+	cases := []struct {
+		qname string
+		want  bool
+	}{
+		{"ads-1.example.com", true},
+		{"ads-banner.example.com", true},
+		{"sub.ads-1.example.com", true},
+		{"ads.example.com", false},
+		{"other.example.com", false},
+	}
+	for _, c := range cases {
+		if got := trie.blocked(c.qname); got != c.want {
+			t.Errorf("blocked(%q) = %v, want %v", c.qname, got, c.want)
+		}
+	}
+}
+
+func TestRuleTrieWildcardAllowOverride(t *testing.T) {
+	trie := newRuleTrie()
+	trie.insert("*.example.com", false)
+	trie.insert("safe.example.com", true)
+
+	if !trie.blocked("ads.example.com") {
+		t.Error("expected ads.example.com to be blocked by wildcard rule")
+	}
+	if trie.blocked("safe.example.com") {
+		t.Error("expected safe.example.com to be allowed by the more specific override")
+	}
+}
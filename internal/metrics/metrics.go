@@ -0,0 +1,136 @@
+// Package metrics exposes Prometheus instrumentation for dns300. A Metrics
+// value always exists and its observer methods are always safe to call;
+// they're no-ops until Start is called, so callers don't need to special-case
+// "metrics disabled" and the cost of leaving it off is a single atomic load.
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type Metrics struct {
+	enabled atomic.Bool
+	server  *http.Server
+
+	registry *prometheus.Registry
+
+	queriesTotal    *prometheus.CounterVec
+	upstreamLatency *prometheus.HistogramVec
+	upstreamErrors  *prometheus.CounterVec
+	cacheHits       prometheus.Counter
+	cacheMisses     prometheus.Counter
+	blocklistHits   prometheus.Counter
+}
+
+// New builds a Metrics instance. It does not start serving /metrics; call
+// Start for that.
+func New() *Metrics {
+	m := &Metrics{
+		queriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns300_queries_total",
+			Help: "Total DNS queries handled, by device, query type and response code.",
+		}, []string{"device", "qtype", "rcode"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dns300_upstream_latency_seconds",
+			Help:    "Upstream exchange latency, by upstream address and transport.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"upstream", "transport"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dns300_upstream_errors_total",
+			Help: "Upstream exchange errors, by upstream address and transport.",
+		}, []string{"upstream", "transport"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns300_cache_hits_total",
+			Help: "Response cache hits.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns300_cache_misses_total",
+			Help: "Response cache misses.",
+		}),
+		blocklistHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "dns300_blocklist_hits_total",
+			Help: "Queries answered directly from the blocklist filter.",
+		}),
+	}
+
+	m.registry = prometheus.NewRegistry()
+	m.registry.MustRegister(
+		m.queriesTotal,
+		m.upstreamLatency,
+		m.upstreamErrors,
+		m.cacheHits,
+		m.cacheMisses,
+		m.blocklistHits,
+	)
+
+	return m
+}
+
+// Start begins serving /metrics on addr and marks the collectors enabled.
+func (m *Metrics) Start(addr string) error {
+	m.enabled.Store(true)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	m.server = &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		if err := m.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics server failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Stop shuts down the metrics HTTP server, if running.
+func (m *Metrics) Stop(ctx context.Context) error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(ctx)
+}
+
+func (m *Metrics) ObserveQuery(device, qtype, rcode string) {
+	if !m.enabled.Load() {
+		return
+	}
+	m.queriesTotal.WithLabelValues(device, qtype, rcode).Inc()
+}
+
+func (m *Metrics) ObserveUpstream(upstream, transport string, latency time.Duration, err error) {
+	if !m.enabled.Load() {
+		return
+	}
+	m.upstreamLatency.WithLabelValues(upstream, transport).Observe(latency.Seconds())
+	if err != nil {
+		m.upstreamErrors.WithLabelValues(upstream, transport).Inc()
+	}
+}
+
+func (m *Metrics) ObserveCacheHit() {
+	if !m.enabled.Load() {
+		return
+	}
+	m.cacheHits.Inc()
+}
+
+func (m *Metrics) ObserveCacheMiss() {
+	if !m.enabled.Load() {
+		return
+	}
+	m.cacheMisses.Inc()
+}
+
+func (m *Metrics) ObserveBlocklistHit() {
+	if !m.enabled.Load() {
+		return
+	}
+	m.blocklistHits.Inc()
+}
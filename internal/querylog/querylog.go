@@ -0,0 +1,170 @@
+// Package querylog writes a structured, async query log. Logging is opt-in
+// and costs a single atomic load when disabled; when enabled, entries are
+// delivered over a bounded channel so a slow disk never blocks ServeDNS —
+// entries are dropped (and counted) instead.
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// queueSize bounds how many log entries can be pending before Log starts
+// dropping them.
+const queueSize = 1024
+
+// Entry is a single query log record.
+type Entry struct {
+	Timestamp time.Time     `json:"timestamp"`
+	ClientIP  string        `json:"client_ip"`
+	Device    string        `json:"device,omitempty"`
+	Question  string        `json:"question"`
+	Qtype     string        `json:"qtype"`
+	Answer    string        `json:"answer,omitempty"`
+	Rcode     string        `json:"rcode"`
+	Upstream  string        `json:"upstream,omitempty"`
+	Elapsed   time.Duration `json:"-"`
+}
+
+// MarshalJSON encodes Entry with Elapsed converted to fractional
+// milliseconds, since time.Duration marshals as a raw nanosecond count.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type alias Entry
+	return json.Marshal(struct {
+		alias
+		ElapsedMs float64 `json:"elapsed_ms"`
+	}{
+		alias:     alias(e),
+		ElapsedMs: e.Elapsed.Seconds() * 1000,
+	})
+}
+
+// Writer asynchronously appends Entry records to a size-rotated file.
+type Writer struct {
+	enabled atomic.Bool
+	dropped atomic.Int64
+
+	ch chan Entry
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	file     *os.File
+	path     string
+	maxBytes int64
+	size     int64
+}
+
+// New opens (or creates) path and starts the background writer goroutine.
+// maxBytes is the size at which the file is rotated to path+".1".
+func New(path string, maxBytes int64) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open query log %s: %w", path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if maxBytes <= 0 {
+		maxBytes = 100 * 1024 * 1024
+	}
+
+	w := &Writer{
+		ch:       make(chan Entry, queueSize),
+		file:     f,
+		path:     path,
+		maxBytes: maxBytes,
+		size:     info.Size(),
+	}
+
+	w.wg.Add(1)
+	go w.run()
+
+	return w, nil
+}
+
+// Enable turns on logging. Log is a no-op until this is called.
+func (w *Writer) Enable() {
+	w.enabled.Store(true)
+}
+
+// Dropped returns the number of entries discarded because the queue was full.
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Log enqueues e for writing. It never blocks: if the queue is full the
+// entry is dropped and Dropped's counter is incremented.
+func (w *Writer) Log(e Entry) {
+	if !w.enabled.Load() {
+		return
+	}
+	select {
+	case w.ch <- e:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// Close stops accepting new entries and flushes pending ones to disk.
+func (w *Writer) Close() error {
+	close(w.ch)
+	w.wg.Wait()
+	return w.file.Close()
+}
+
+func (w *Writer) run() {
+	defer w.wg.Done()
+	for e := range w.ch {
+		w.write(e)
+	}
+}
+
+func (w *Writer) write(e Entry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return
+	}
+
+	n, err := w.file.Write(data)
+	if err != nil {
+		return
+	}
+	w.size += int64(n)
+
+	if w.size >= w.maxBytes {
+		w.rotate()
+	}
+}
+
+// rotate moves the current log to path+".1" (overwriting any previous
+// backup) and opens a fresh file in its place. Must be called with w.mu held.
+func (w *Writer) rotate() {
+	w.file.Close()
+
+	backup := w.path + ".1"
+	os.Rename(w.path, backup)
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		// Nothing we can do but stop logging rather than panic mid-query.
+		w.file = nil
+		return
+	}
+	w.file = f
+	w.size = 0
+}
@@ -0,0 +1,77 @@
+// Package routing implements longest-suffix domain matching used for
+// split-horizon upstream selection (e.g. internal zones to internal
+// resolvers, geo-specific TLDs to regional resolvers).
+package routing
+
+import "dns300/internal/dnsname"
+
+// Rule pairs a domain suffix with the upstreams queries matching it should
+// use instead of the default/device upstreams.
+type Rule struct {
+	Suffix    string
+	Upstreams []string
+}
+
+// Trie is a longest-suffix matcher over DNS labels, built once at config
+// load and consulted per query.
+type Trie struct {
+	root *node
+}
+
+type node struct {
+	children  map[string]*node
+	upstreams []string
+	terminal  bool
+}
+
+// NewTrie builds a Trie from rules. Later rules with the same suffix
+// overwrite earlier ones.
+func NewTrie(rules []Rule) *Trie {
+	t := &Trie{root: &node{children: make(map[string]*node)}}
+	for _, r := range rules {
+		t.insert(r)
+	}
+	return t
+}
+
+func (t *Trie) insert(r Rule) {
+	labels := dnsname.SplitLabels(r.Suffix)
+	n := t.root
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := labels[i]
+		child, ok := n.children[label]
+		if !ok {
+			child = &node{children: make(map[string]*node)}
+			n.children[label] = child
+		}
+		n = child
+	}
+	n.terminal = true
+	n.upstreams = r.Upstreams
+}
+
+// Match walks qname's labels from the top-level label down and returns the
+// upstreams for the longest registered suffix, if any matched.
+func (t *Trie) Match(qname string) ([]string, bool) {
+	if t == nil {
+		return nil, false
+	}
+
+	labels := dnsname.SplitLabels(qname)
+	n := t.root
+	var best []string
+	var matched bool
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, ok := n.children[labels[i]]
+		if !ok {
+			break
+		}
+		n = child
+		if n.terminal {
+			best = n.upstreams
+			matched = true
+		}
+	}
+	return best, matched
+}
+
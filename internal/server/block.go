@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// blockedResponse builds the answer for a query matched by the blocklist
+// filter, shaped by the configured blocking_mode (default "nxdomain").
+func (s *Server) blockedResponse(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	switch s.cfg.BlockingMode {
+	case "zeroip":
+		m.Answer = zeroIPAnswer(r)
+	case "custom_ip":
+		m.Answer = customIPAnswer(r, s.cfg.BlockingIP)
+	default:
+		m.SetRcode(r, dns.RcodeNameError)
+	}
+
+	return m
+}
+
+func zeroIPAnswer(r *dns.Msg) []dns.RR {
+	if len(r.Question) == 0 {
+		return nil
+	}
+	return ipAnswer(r, net.IPv4zero, net.IPv6zero)
+}
+
+func customIPAnswer(r *dns.Msg, customIP string) []dns.RR {
+	ip := net.ParseIP(customIP)
+	if ip == nil {
+		return nil
+	}
+	return ipAnswer(r, ip, ip)
+}
+
+// ipAnswer returns a single A or AAAA record for the question's qtype,
+// using v4 or v6 respectively. Any other qtype gets no answer (NOERROR with
+// an empty answer section, matching how upstreams answer unrelated types
+// for a blocked name).
+func ipAnswer(r *dns.Msg, v4, v6 net.IP) []dns.RR {
+	q := r.Question[0]
+	hdr := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: 60}
+
+	switch q.Qtype {
+	case dns.TypeA:
+		hdr.Rrtype = dns.TypeA
+		return []dns.RR{&dns.A{Hdr: hdr, A: v4}}
+	case dns.TypeAAAA:
+		hdr.Rrtype = dns.TypeAAAA
+		return []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: v6}}
+	default:
+		return nil
+	}
+}
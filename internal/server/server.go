@@ -2,11 +2,17 @@ package server
 
 import (
 	"context"
+	"dns300/internal/cache"
 	"dns300/internal/config"
 	"dns300/internal/device"
+	"dns300/internal/filter"
+	"dns300/internal/metrics"
+	"dns300/internal/querylog"
 	"dns300/internal/upstream"
 	"log"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -15,17 +21,54 @@ type Server struct {
 	cfg        *config.Config
 	devManager *device.Manager
 	client     *upstream.Client
+	cache      *cache.Cache     // nil if caching is disabled
+	filter     *filter.Filter   // nil if blocklist filtering is disabled
+	metrics    *metrics.Metrics // always set; observer methods no-op until Start is called
+	querylog   *querylog.Writer // nil if the query log is disabled
 }
 
-func NewServer(cfg *config.Config, devManager *device.Manager, client *upstream.Client) *Server {
-	return &Server{
+func NewServer(cfg *config.Config, devManager *device.Manager, client *upstream.Client, m *metrics.Metrics) *Server {
+	s := &Server{
 		cfg:        cfg,
 		devManager: devManager,
 		client:     client,
+		metrics:    m,
 	}
+
+	if cfg.Cache.MaxEntries > 0 {
+		s.cache = cache.New(cfg.Cache.MaxEntries, time.Duration(cfg.Cache.NegativeTTL), time.Duration(cfg.Cache.ServeStale))
+	}
+
+	if len(cfg.Blocklists) > 0 || len(cfg.UserRules) > 0 {
+		sources := make([]filter.Source, len(cfg.Blocklists))
+		for i, bl := range cfg.Blocklists {
+			sources[i] = filter.Source{URL: bl.URL, Path: bl.Path, Format: filter.Format(bl.Format)}
+		}
+
+		s.filter = filter.New(sources, cfg.UserRules)
+		if err := s.filter.Refresh(context.Background()); err != nil {
+			log.Printf("Initial blocklist load failed: %v", err)
+		}
+		s.filter.StartAutoRefresh(context.Background(), time.Duration(cfg.FilterRefresh))
+	}
+
+	if cfg.QueryLog.Enabled {
+		maxBytes := int64(cfg.QueryLog.MaxSizeMB) * 1024 * 1024
+		w, err := querylog.New(cfg.QueryLog.Path, maxBytes)
+		if err != nil {
+			log.Printf("Failed to open query log: %v", err)
+		} else {
+			w.Enable()
+			s.querylog = w
+		}
+	}
+
+	return s
 }
 
 func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+
 	// Parse Client IP
 	clientAddr := w.RemoteAddr()
 	ipStr, _, err := net.SplitHostPort(clientAddr.String())
@@ -35,13 +78,20 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 	clientIP := net.ParseIP(ipStr)
 
+	var qname string
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+	}
+
 	// Determine Upstreams
 	var upstreams []string
 	var tlsVerify bool = true
 
 	dev := s.devManager.Get(clientIP)
+	var deviceName string
 	if dev != nil {
 		// Device found, use device settings
+		deviceName = dev.Name
 		upstreams = dev.Upstreams
 		tlsVerify = dev.TLSVerify
 	} else {
@@ -49,6 +99,47 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		upstreams = s.cfg.Upstreams
 	}
 
+	// Domain-based routing takes precedence over the device/default
+	// upstreams: device rules first, then the global rules.
+	if qname != "" {
+		if dev != nil {
+			if ruleUpstreams, ok := dev.Rules.Match(qname); ok {
+				upstreams = ruleUpstreams
+			} else if ruleUpstreams, ok := s.devManager.Rules().Match(qname); ok {
+				upstreams = ruleUpstreams
+			}
+		} else if ruleUpstreams, ok := s.devManager.Rules().Match(qname); ok {
+			upstreams = ruleUpstreams
+		}
+	}
+
+	// Blocklist filtering takes priority over both the cache and forwarding.
+	if s.filter != nil && qname != "" {
+		filterEnabled := dev == nil || dev.FilterEnabled
+		if filterEnabled && s.filter.Blocked(qname) {
+			s.metrics.ObserveBlocklistHit()
+			s.finish(w, r, s.blockedResponse(r), clientIP, deviceName, "blocklist", start)
+			return
+		}
+	}
+
+	// Consult the response cache before forwarding
+	var cacheKey cache.Key
+	haveCacheKey := s.cache != nil && qname != ""
+	if haveCacheKey {
+		cacheKey = cache.KeyForRequest(r, upstreams)
+		if cached, stale, ok := s.cache.Get(cacheKey); ok {
+			s.metrics.ObserveCacheHit()
+			if stale {
+				// Serve the stale entry immediately and refresh it in the background.
+				go s.refreshCache(cacheKey, r.Copy(), upstreams, tlsVerify)
+			}
+			s.finish(w, r, cached, clientIP, deviceName, "cache", start)
+			return
+		}
+		s.metrics.ObserveCacheMiss()
+	}
+
 	// Forward Query
 	ctx := context.Background()
 	resp, err := s.client.Exchange(ctx, r, upstreams, tlsVerify)
@@ -57,11 +148,71 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		// Return SERVFAIL
 		m := new(dns.Msg)
 		m.SetRcode(r, dns.RcodeServerFailure)
-		w.WriteMsg(m)
+		s.finish(w, r, m, clientIP, deviceName, strings.Join(upstreams, ","), start)
+		return
+	}
+
+	if haveCacheKey {
+		s.cache.Set(cacheKey, resp)
+	}
+
+	s.finish(w, r, resp, clientIP, deviceName, strings.Join(upstreams, ","), start)
+}
+
+// refreshCache re-runs the upstream exchange for a stale cache entry so the
+// next query after a serve-stale response gets a fresh answer.
+func (s *Server) refreshCache(key cache.Key, req *dns.Msg, upstreams []string, tlsVerify bool) {
+	resp, err := s.client.Exchange(context.Background(), req, upstreams, tlsVerify)
+	if err != nil {
+		log.Printf("Failed to refresh cached query: %v", err)
 		return
 	}
+	s.cache.Set(key, resp)
+}
 
-	// Write Response
-	resp.Id = r.Id // Use request ID
+// finish writes resp to the client and records metrics/query-log entries
+// for the request. source describes where the answer came from: "cache",
+// "blocklist", or the comma-joined upstream list that was raced.
+func (s *Server) finish(w dns.ResponseWriter, r, resp *dns.Msg, clientIP net.IP, deviceName, source string, start time.Time) {
+	resp.Id = r.Id
 	w.WriteMsg(resp)
+
+	var qname, qtype string
+	if len(r.Question) > 0 {
+		qname = r.Question[0].Name
+		qtype = dns.TypeToString[r.Question[0].Qtype]
+	}
+	rcode := dns.RcodeToString[resp.Rcode]
+
+	s.metrics.ObserveQuery(deviceName, qtype, rcode)
+
+	if s.querylog == nil {
+		return
+	}
+
+	answers := make([]string, 0, len(resp.Answer))
+	for _, rr := range resp.Answer {
+		answers = append(answers, rr.String())
+	}
+
+	s.querylog.Log(querylog.Entry{
+		Timestamp: start,
+		ClientIP:  clientIP.String(),
+		Device:    deviceName,
+		Question:  qname,
+		Qtype:     qtype,
+		Answer:    strings.Join(answers, "; "),
+		Rcode:     rcode,
+		Upstream:  source,
+		Elapsed:   time.Since(start),
+	})
+}
+
+// Close flushes and releases resources owned by the server, such as the
+// query log file. It should be called once on shutdown.
+func (s *Server) Close() error {
+	if s.querylog == nil {
+		return nil
+	}
+	return s.querylog.Close()
 }
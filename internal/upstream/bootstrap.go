@@ -0,0 +1,148 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// bootstrapEntry is a cached A/AAAA lookup result for a single hostname.
+type bootstrapEntry struct {
+	ips       []net.IP
+	expiresAt time.Time
+}
+
+// bootstrapResolver resolves DoH/DoT hostnames against a fixed list of
+// bootstrap DNS servers, avoiding a dependency on the OS resolver (which on
+// a router is dns300 itself).
+type bootstrapResolver struct {
+	servers []string
+
+	mu    sync.Mutex
+	cache map[string]bootstrapEntry
+}
+
+func newBootstrapResolver(servers []string) *bootstrapResolver {
+	return &bootstrapResolver{
+		servers: servers,
+		cache:   make(map[string]bootstrapEntry),
+	}
+}
+
+// resolve returns the cached or freshly-looked-up IPs for host, querying the
+// configured bootstrap servers for A and AAAA records.
+func (b *bootstrapResolver) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if b == nil || len(b.servers) == 0 {
+		return nil, fmt.Errorf("no bootstrap servers configured to resolve %q", host)
+	}
+
+	b.mu.Lock()
+	if entry, ok := b.cache[host]; ok && time.Now().Before(entry.expiresAt) {
+		b.mu.Unlock()
+		return entry.ips, nil
+	}
+	b.mu.Unlock()
+
+	ips, ttl, err := b.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	b.cache[host] = bootstrapEntry{ips: ips, expiresAt: time.Now().Add(ttl)}
+	b.mu.Unlock()
+
+	return ips, nil
+}
+
+// lookup queries each bootstrap server in turn for A and AAAA records,
+// returning on the first server that answers either.
+func (b *bootstrapResolver) lookup(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	fqdn := dns.Fqdn(host)
+
+	client := new(dns.Client)
+	if deadline, ok := ctx.Deadline(); ok {
+		client.Timeout = time.Until(deadline)
+	} else {
+		client.Timeout = 5 * time.Second
+	}
+
+	var lastErr error
+	for _, server := range b.servers {
+		addr := server
+		if !strings.Contains(addr, ":") {
+			addr = addr + ":53"
+		}
+
+		var ips []net.IP
+		ttl := uint32(0)
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			q := new(dns.Msg)
+			q.SetQuestion(fqdn, qtype)
+
+			resp, _, err := client.ExchangeContext(ctx, q, addr)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			for _, rr := range resp.Answer {
+				switch rec := rr.(type) {
+				case *dns.A:
+					ips = append(ips, rec.A)
+					ttl = rec.Hdr.Ttl
+				case *dns.AAAA:
+					ips = append(ips, rec.AAAA)
+					ttl = rec.Hdr.Ttl
+				}
+			}
+		}
+
+		if len(ips) > 0 {
+			if ttl == 0 {
+				ttl = 60
+			}
+			return ips, time.Duration(ttl) * time.Second, nil
+		}
+	}
+
+	if lastErr != nil {
+		return nil, 0, fmt.Errorf("bootstrap lookup for %s failed: %w", host, lastErr)
+	}
+	return nil, 0, fmt.Errorf("bootstrap lookup for %s returned no records", host)
+}
+
+// dialContext is used as the http.Transport DialContext for DoH clients: it
+// resolves non-IP-literal hosts via the bootstrap resolver and dials the
+// resolved IP directly, while leaving the original addr (and therefore the
+// TLS ServerName/Host header) untouched.
+func (b *bootstrapResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		ips, err := b.resolve(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var lastErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"dns300/internal/metrics"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
@@ -17,9 +19,23 @@ import (
 type Client struct {
 	httpClientVerify   *http.Client // TLS verification enabled
 	httpClientInsecure *http.Client // TLS verification disabled
+
+	dotPool       *dotPool           // pooled DoT connections, keyed by upstream address
+	dnscryptCerts *dnscryptCertCache // cached DNSCrypt resolver certificates
+	bootstrap     *bootstrapResolver // resolves DoH/DoT hostnames without the OS resolver
+	metrics       *metrics.Metrics   // upstream latency/error observer; safe to call when disabled
 }
 
-func NewClient() *Client {
+// NewClient builds a Client. bootstrapServers are used to resolve
+// hostname-based DoH/DoT upstreams instead of the OS resolver, which on a
+// router running dns300 would otherwise create a bootstrap loop; with none
+// configured, such upstreams fail to resolve and only IP-literal DoH/DoT
+// upstreams can be reached. m
+// records upstream latency/errors and is a no-op until m.Start is called.
+func NewClient(bootstrapServers []string, m *metrics.Metrics) *Client {
+	bootstrap := newBootstrapResolver(bootstrapServers)
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+
 	return &Client{
 		httpClientVerify: &http.Client{
 			Timeout: 5 * time.Second,
@@ -27,6 +43,7 @@ func NewClient() *Client {
 				Proxy:             http.ProxyFromEnvironment,
 				TLSClientConfig:   &tls.Config{InsecureSkipVerify: false},
 				ForceAttemptHTTP2: true,
+				DialContext:       bootstrap.dialContext(dialer),
 			},
 		},
 		httpClientInsecure: &http.Client{
@@ -35,8 +52,13 @@ func NewClient() *Client {
 				Proxy:             http.ProxyFromEnvironment,
 				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
 				ForceAttemptHTTP2: true,
+				DialContext:       bootstrap.dialContext(dialer),
 			},
 		},
+		dotPool:       newDoTPool(),
+		dnscryptCerts: newDNSCryptCertCache(),
+		bootstrap:     bootstrap,
+		metrics:       m,
 	}
 }
 
@@ -65,17 +87,35 @@ func (c *Client) Exchange(ctx context.Context, req *dns.Msg, upstreams []string,
 			// Select query method based on upstream format
 			var msg *dns.Msg
 			var err error
+			var transport string
+			start := time.Now()
 
-			if strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://") {
+			switch {
+			case strings.HasPrefix(u, "https://") || strings.HasPrefix(u, "http://"):
+				transport = "doh"
 				msg, err = c.queryDoH(ctx, req, u, tlsVerify)
-			} else {
-				// Assume UDP regular DNS if not http(s)
+			case strings.HasPrefix(u, "tls://"):
+				transport = "dot"
+				msg, err = c.queryDoT(ctx, req, u, tlsVerify)
+			case strings.HasPrefix(u, "sdns://"):
+				transport = "dnscrypt"
+				msg, err = c.queryDNSCrypt(ctx, req, u)
+			default:
+				// Assume UDP regular DNS if not a recognized scheme
+				transport = "udp"
 				if !strings.Contains(u, ":") {
 					u = u + ":53"
 				}
 				msg, err = c.queryUDP(ctx, req, u)
 			}
 
+			// If ctx was already canceled, this upstream merely lost the race
+			// against a faster one (Exchange cancels ctx as soon as it returns),
+			// not a real upstream failure, so don't count it as one.
+			if err == nil || ctx.Err() == nil {
+				c.metrics.ObserveUpstream(u, transport, time.Since(start), err)
+			}
+
 			select {
 			case resultChan <- result{msg: msg, err: err}:
 			case <-ctx.Done():
@@ -0,0 +1,219 @@
+package upstream
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const dnscryptCertMagic = "DNSC"
+
+// dnscryptCert is a resolver's short-term certificate, fetched once per
+// provider and cached for its validity window.
+type dnscryptCert struct {
+	serverPk    [32]byte
+	clientMagic [8]byte
+	expiresAt   time.Time
+}
+
+// dnscryptCertCache caches certificates per stamp server address so a
+// repeated query doesn't re-fetch and re-validate the certificate.
+type dnscryptCertCache struct {
+	mu    sync.Mutex
+	certs map[string]*dnscryptCert
+}
+
+func newDNSCryptCertCache() *dnscryptCertCache {
+	return &dnscryptCertCache{certs: make(map[string]*dnscryptCert)}
+}
+
+func (c *dnscryptCertCache) get(addr string) (*dnscryptCert, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, ok := c.certs[addr]
+	if !ok || time.Now().After(cert.expiresAt) {
+		return nil, false
+	}
+	return cert, true
+}
+
+func (c *dnscryptCertCache) set(addr string, cert *dnscryptCert) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs[addr] = cert
+}
+
+// queryDNSCrypt performs a DNSCrypt exchange against an "sdns://" upstream,
+// fetching (and caching) the resolver certificate on first use.
+func (c *Client) queryDNSCrypt(ctx context.Context, req *dns.Msg, u string) (*dns.Msg, error) {
+	stamp, err := parseDNSCryptStamp(u)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, ok := c.dnscryptCerts.get(stamp.serverAddr)
+	if !ok {
+		cert, err = c.fetchDNSCryptCert(ctx, stamp)
+		if err != nil {
+			return nil, fmt.Errorf("dnscrypt cert fetch: %w", err)
+		}
+		c.dnscryptCerts.set(stamp.serverAddr, cert)
+	}
+
+	msg, err := c.dnscryptExchange(ctx, req, stamp, cert)
+	if err != nil {
+		// The cached certificate may have been rotated server-side; drop it
+		// so the next query re-fetches instead of failing forever.
+		c.dnscryptCerts.mu.Lock()
+		delete(c.dnscryptCerts.certs, stamp.serverAddr)
+		c.dnscryptCerts.mu.Unlock()
+		return nil, err
+	}
+	return msg, nil
+}
+
+// fetchDNSCryptCert retrieves the resolver's current certificate via a plain
+// TXT query for the provider name, picking the longest-valid one returned.
+func (c *Client) fetchDNSCryptCert(ctx context.Context, stamp *dnscryptStamp) (*dnscryptCert, error) {
+	q := new(dns.Msg)
+	q.SetQuestion(dns.Fqdn(stamp.providerName), dns.TypeTXT)
+
+	dnsClient := new(dns.Client)
+	if deadline, ok := ctx.Deadline(); ok {
+		dnsClient.Timeout = time.Until(deadline)
+	} else {
+		dnsClient.Timeout = 5 * time.Second
+	}
+
+	resp, _, err := dnsClient.ExchangeContext(ctx, q, stamp.serverAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *dnscryptCert
+	for _, rr := range resp.Answer {
+		txt, ok := rr.(*dns.TXT)
+		if !ok {
+			continue
+		}
+		cert, err := parseDNSCryptCert([]byte(strings.Join(txt.Txt, "")), stamp)
+		if err != nil {
+			continue
+		}
+		if latest == nil || cert.expiresAt.After(latest.expiresAt) {
+			latest = cert
+		}
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("no valid dnscrypt certificate found for %s", stamp.providerName)
+	}
+	return latest, nil
+}
+
+// parseDNSCryptCert decodes the binary certificate carried in a resolver's
+// TXT record:
+//
+//	magic(4) | es-version(2) | minor-version(2) | signature(64) |
+//	resolver-pk(32) | client-magic(8) | serial(4) | ts-start(4) | ts-end(4)
+//
+// The signature covers the signed region (everything after it) and is
+// verified against stamp.serverPk, the provider's long-term key from the
+// sdns:// stamp, so an on-path attacker can't substitute their own
+// short-term key.
+func parseDNSCryptCert(data []byte, stamp *dnscryptStamp) (*dnscryptCert, error) {
+	const certLen = 4 + 2 + 2 + 64 + 32 + 8 + 4 + 4 + 4
+	if len(data) < certLen || string(data[:4]) != dnscryptCertMagic {
+		return nil, fmt.Errorf("malformed dnscrypt certificate")
+	}
+
+	signature := data[8:72]
+	signed := data[72:124]
+	if !ed25519.Verify(stamp.serverPk[:], signed, signature) {
+		return nil, fmt.Errorf("dnscrypt certificate signature verification failed")
+	}
+
+	cert := &dnscryptCert{}
+	copy(cert.serverPk[:], data[72:104])
+	copy(cert.clientMagic[:], data[104:112])
+	tsEnd := binary.BigEndian.Uint32(data[120:124])
+	cert.expiresAt = time.Unix(int64(tsEnd), 0)
+	return cert, nil
+}
+
+// dnscryptExchange encrypts req with the resolver's current certificate,
+// sends it over UDP and decrypts the response.
+func (c *Client) dnscryptExchange(ctx context.Context, req *dns.Msg, stamp *dnscryptStamp, cert *dnscryptCert) (*dns.Msg, error) {
+	packed, err := req.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:12]); err != nil {
+		return nil, err
+	}
+	sealed := box.Seal(nil, packed, &nonce, &cert.serverPk, priv)
+
+	var out bytes.Buffer
+	out.Write(cert.clientMagic[:])
+	out.Write(pub[:])
+	out.Write(nonce[:12])
+	out.Write(sealed)
+
+	timeout := 5 * time.Second
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	conn, err := net.Dial("udp", stamp.serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(out.Bytes()); err != nil {
+		return nil, err
+	}
+
+	respBuf := make([]byte, dns.MaxMsgSize)
+	n, err := conn.Read(respBuf)
+	if err != nil {
+		return nil, err
+	}
+	respBuf = respBuf[:n]
+
+	// Server response: resolver-magic(8) | nonce(24) | sealed box.
+	if len(respBuf) < 32 {
+		return nil, fmt.Errorf("dnscrypt response too short")
+	}
+	var respNonce [24]byte
+	copy(respNonce[:], respBuf[8:32])
+
+	plain, ok := box.Open(nil, respBuf[32:], &respNonce, &cert.serverPk, priv)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt dnscrypt response")
+	}
+
+	msg := new(dns.Msg)
+	if err := msg.Unpack(plain); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,145 @@
+package upstream
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// buildTestCert assembles a 124-byte certificate buffer matching the layout
+// documented on parseDNSCryptCert, signing the signed region with signingKey
+// so it verifies against a stamp carrying the matching public key.
+func buildTestCert(signingKey ed25519.PrivateKey, serverPk [32]byte, clientMagic [8]byte, tsEnd uint32) []byte {
+	buf := make([]byte, 124)
+	copy(buf[0:4], dnscryptCertMagic)
+	copy(buf[72:104], serverPk[:])
+	copy(buf[104:112], clientMagic[:])
+	binary.BigEndian.PutUint32(buf[120:124], tsEnd)
+	sig := ed25519.Sign(signingKey, buf[72:124])
+	copy(buf[8:72], sig)
+	return buf
+}
+
+func testStampForKey(pub ed25519.PublicKey) *dnscryptStamp {
+	stamp := &dnscryptStamp{serverAddr: "resolver.example:443", providerName: "2.dnscrypt-cert.example"}
+	copy(stamp.serverPk[:], pub)
+	return stamp
+}
+
+func TestParseDNSCryptCert(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("generate signing key: %v", err)
+	}
+
+	var wantPk [32]byte
+	for i := range wantPk {
+		wantPk[i] = byte(i + 1)
+	}
+	wantMagic := [8]byte{0xDE, 0xAD, 0xBE, 0xEF, 0x01, 0x02, 0x03, 0x04}
+	wantExpiry := uint32(1893456000) // 2030-01-01T00:00:00Z
+
+	cert, err := parseDNSCryptCert(buildTestCert(priv, wantPk, wantMagic, wantExpiry), testStampForKey(pub))
+	if err != nil {
+		t.Fatalf("parseDNSCryptCert: %v", err)
+	}
+	if cert.serverPk != wantPk {
+		t.Errorf("serverPk = %x, want %x", cert.serverPk, wantPk)
+	}
+	if cert.clientMagic != wantMagic {
+		t.Errorf("clientMagic = %x, want %x", cert.clientMagic, wantMagic)
+	}
+	if want := time.Unix(int64(wantExpiry), 0); !cert.expiresAt.Equal(want) {
+		t.Errorf("expiresAt = %v, want %v", cert.expiresAt, want)
+	}
+}
+
+func TestParseDNSCryptCertTooShort(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := parseDNSCryptCert(make([]byte, 123), testStampForKey(pub)); err == nil {
+		t.Fatal("expected error for truncated certificate")
+	}
+}
+
+func TestParseDNSCryptCertBadMagic(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	buf := buildTestCert(priv, [32]byte{}, [8]byte{}, 0)
+	copy(buf[0:4], "XXXX")
+	if _, err := parseDNSCryptCert(buf, testStampForKey(pub)); err == nil {
+		t.Fatal("expected error for bad magic")
+	}
+}
+
+func TestParseDNSCryptCertBadSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	buf := buildTestCert(priv, [32]byte{}, [8]byte{}, 1893456000)
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if _, err := parseDNSCryptCert(buf, testStampForKey(otherPub)); err == nil {
+		t.Fatal("expected error for certificate signed by a different key")
+	}
+
+	buf[72] ^= 0xFF // tamper with the signed region after signing
+	if _, err := parseDNSCryptCert(buf, testStampForKey(pub)); err == nil {
+		t.Fatal("expected error for tampered signed region")
+	}
+}
+
+// buildTestStamp assembles a minimal sdns:// stamp string for a DNSCrypt
+// resolver with the given address, public key and provider name.
+func buildTestStamp(addr string, pk [32]byte, providerName string) string {
+	var data []byte
+	data = append(data, stampProtocolDNSCrypt)
+	data = append(data, make([]byte, 8)...) // properties bitfield
+	data = appendLPString(data, addr)
+	data = appendLPString(data, string(pk[:]))
+	data = appendLPString(data, providerName)
+	return "sdns://" + base64.RawURLEncoding.EncodeToString(data)
+}
+
+func appendLPString(data []byte, s string) []byte {
+	data = append(data, byte(len(s)))
+	return append(data, s...)
+}
+
+func TestParseDNSCryptStamp(t *testing.T) {
+	var pk [32]byte
+	for i := range pk {
+		pk[i] = byte(255 - i)
+	}
+
+	stamp, err := parseDNSCryptStamp(buildTestStamp("resolver.example:443", pk, "2.dnscrypt-cert.example"))
+	if err != nil {
+		t.Fatalf("parseDNSCryptStamp: %v", err)
+	}
+	if stamp.serverAddr != "resolver.example:443" {
+		t.Errorf("serverAddr = %q, want %q", stamp.serverAddr, "resolver.example:443")
+	}
+	if stamp.serverPk != pk {
+		t.Errorf("serverPk = %x, want %x", stamp.serverPk, pk)
+	}
+	if stamp.providerName != "2.dnscrypt-cert.example" {
+		t.Errorf("providerName = %q, want %q", stamp.providerName, "2.dnscrypt-cert.example")
+	}
+}
+
+func TestParseDNSCryptStampDefaultPort(t *testing.T) {
+	var pk [32]byte
+	stamp, err := parseDNSCryptStamp(buildTestStamp("resolver.example", pk, "2.dnscrypt-cert.example"))
+	if err != nil {
+		t.Fatalf("parseDNSCryptStamp: %v", err)
+	}
+	if stamp.serverAddr != "resolver.example:443" {
+		t.Errorf("serverAddr = %q, want default port 443 appended", stamp.serverAddr)
+	}
+}
+
+func TestParseDNSCryptStampWrongProtocol(t *testing.T) {
+	data := []byte{0x02} // not stampProtocolDNSCrypt
+	u := "sdns://" + base64.RawURLEncoding.EncodeToString(data)
+	if _, err := parseDNSCryptStamp(u); err == nil {
+		t.Fatal("expected error for unsupported stamp protocol")
+	}
+}
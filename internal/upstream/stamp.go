@@ -0,0 +1,77 @@
+package upstream
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// stampProtocolDNSCrypt is the only stamp protocol byte we support; see
+// https://dnscrypt.info/stamps-specifications for the full set.
+const stampProtocolDNSCrypt = 0x01
+
+// dnscryptStamp is the subset of a decoded sdns:// DNS stamp needed to
+// bootstrap a DNSCrypt session.
+type dnscryptStamp struct {
+	serverAddr   string
+	serverPk     [32]byte
+	providerName string
+}
+
+// parseDNSCryptStamp decodes a "sdns://..." upstream string into its server
+// address, long-term public key and provider name.
+func parseDNSCryptStamp(u string) (*dnscryptStamp, error) {
+	raw := strings.TrimPrefix(u, "sdns://")
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dnscrypt stamp: %w", err)
+	}
+	if len(data) < 1 || data[0] != stampProtocolDNSCrypt {
+		return nil, fmt.Errorf("unsupported dns stamp protocol (only DNSCrypt is supported)")
+	}
+	data = data[1:]
+
+	if len(data) < 8 {
+		return nil, fmt.Errorf("truncated dnscrypt stamp")
+	}
+	data = data[8:] // properties bitfield, not needed to run queries
+
+	addr, data, err := readLPString(data)
+	if err != nil {
+		return nil, fmt.Errorf("dnscrypt stamp address: %w", err)
+	}
+	if !strings.Contains(addr, ":") {
+		addr += ":443"
+	}
+
+	pk, data, err := readLPString(data)
+	if err != nil {
+		return nil, fmt.Errorf("dnscrypt stamp public key: %w", err)
+	}
+	if len(pk) != 32 {
+		return nil, fmt.Errorf("dnscrypt stamp public key must be 32 bytes, got %d", len(pk))
+	}
+
+	providerName, _, err := readLPString(data)
+	if err != nil {
+		return nil, fmt.Errorf("dnscrypt stamp provider name: %w", err)
+	}
+
+	stamp := &dnscryptStamp{serverAddr: addr, providerName: providerName}
+	copy(stamp.serverPk[:], pk)
+	return stamp, nil
+}
+
+// readLPString reads a single length-prefixed (1 byte length + bytes) field
+// and returns it along with the remaining data.
+func readLPString(data []byte) (string, []byte, error) {
+	if len(data) < 1 {
+		return "", nil, fmt.Errorf("truncated field")
+	}
+	n := int(data[0])
+	data = data[1:]
+	if len(data) < n {
+		return "", nil, fmt.Errorf("truncated field")
+	}
+	return string(data[:n]), data[n:], nil
+}